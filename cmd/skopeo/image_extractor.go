@@ -0,0 +1,533 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// imagePathsAnnotation lets an unknown kind pin the JSONPath-like expressions
+// that locate its image fields directly on the resource, e.g.
+// "skopeo.io/imagepaths: spec.image,spec.sidecar.image".
+const imagePathsAnnotation = "skopeo.io/imagepaths"
+
+// imageExtractor knows how to pull image references out of a single
+// unstructured Kubernetes (or CRD) resource, and to rewrite them in place.
+type imageExtractor interface {
+	Extract(obj *unstructured.Unstructured) []string
+	Rewrite(obj *unstructured.Unstructured, rewrite func(image string) string)
+	// ExtractPaths returns the same matches as Extract, each paired with the
+	// RFC 6901 JSON Pointer path (e.g. "/spec/containers/0/image") locating
+	// it within obj, so a JSON Patch can target the right field.
+	ExtractPaths(obj *unstructured.Unstructured) []imagePathMatch
+}
+
+// imagePathMatch pairs an extracted image value with the JSON Pointer path
+// it was found at, for --rewrite-output's JSON Patch format.
+type imagePathMatch struct {
+	path  string
+	value string
+}
+
+// jsonPathExtractor extracts images from a fixed set of JSONPath-like
+// expressions, e.g. "spec.template.spec.containers[*].image".
+type jsonPathExtractor []string
+
+func (paths jsonPathExtractor) Extract(obj *unstructured.Unstructured) (images []string) {
+	for _, path := range paths {
+		images = append(images, evalImagePath(obj.Object, path)...)
+	}
+	return
+}
+
+func (paths jsonPathExtractor) Rewrite(obj *unstructured.Unstructured, rewrite func(image string) string) {
+	for _, path := range paths {
+		setImagePath(obj.Object, path, rewrite)
+	}
+}
+
+func (paths jsonPathExtractor) ExtractPaths(obj *unstructured.Unstructured) (matches []imagePathMatch) {
+	for _, path := range paths {
+		matches = append(matches, evalImagePathPointers(obj.Object, path)...)
+	}
+	return
+}
+
+// argoHelmParamsExtractor extracts images from an ArgoCD Application's
+// spec.source.helm.parameters list, restricted to parameters whose name
+// suggests they carry an image (e.g. "image.repository", "worker.image"),
+// since the list also carries unrelated values like replica counts or
+// hostnames that don't parse as image references.
+type argoHelmParamsExtractor struct{}
+
+func (argoHelmParamsExtractor) Extract(obj *unstructured.Unstructured) (images []string) {
+	for _, param := range argoHelmParams(obj) {
+		name, _ := param["name"].(string)
+		value, ok := param["value"].(string)
+		if !ok || !strings.Contains(strings.ToLower(name), "image") {
+			continue
+		}
+		images = append(images, value)
+	}
+	return
+}
+
+func (argoHelmParamsExtractor) Rewrite(obj *unstructured.Unstructured, rewrite func(image string) string) {
+	for _, param := range argoHelmParams(obj) {
+		name, _ := param["name"].(string)
+		value, ok := param["value"].(string)
+		if !ok || !strings.Contains(strings.ToLower(name), "image") {
+			continue
+		}
+		param["value"] = rewrite(value)
+	}
+}
+
+func (argoHelmParamsExtractor) ExtractPaths(obj *unstructured.Unstructured) (matches []imagePathMatch) {
+	for i, param := range argoHelmParams(obj) {
+		name, _ := param["name"].(string)
+		value, ok := param["value"].(string)
+		if !ok || !strings.Contains(strings.ToLower(name), "image") {
+			continue
+		}
+		matches = append(matches, imagePathMatch{
+			path:  fmt.Sprintf("/spec/source/helm/parameters/%d/value", i),
+			value: value,
+		})
+	}
+	return
+}
+
+// argoHelmParams returns the spec.source.helm.parameters entries of obj as
+// their raw map[string]interface{} form, so callers can inspect name
+// alongside value.
+func argoHelmParams(obj *unstructured.Unstructured) []map[string]interface{} {
+	params, found, err := unstructured.NestedSlice(obj.Object, "spec", "source", "helm", "parameters")
+	if err != nil || !found {
+		return nil
+	}
+	out := make([]map[string]interface{}, 0, len(params))
+	for _, p := range params {
+		if m, ok := p.(map[string]interface{}); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// genericWalkExtractor falls back to a recursive search for the well-known
+// "containers"/"initContainers" arrays, preserving the behavior of the
+// original (pre-extractor) walkImage for kinds with no registered extractor.
+type genericWalkExtractor struct{}
+
+func (genericWalkExtractor) Extract(obj *unstructured.Unstructured) []string {
+	return walkImage(obj.Object)
+}
+
+func (genericWalkExtractor) Rewrite(obj *unstructured.Unstructured, rewrite func(image string) string) {
+	rewriteWalkImage(obj.Object, rewrite)
+}
+
+func (genericWalkExtractor) ExtractPaths(obj *unstructured.Unstructured) []imagePathMatch {
+	return walkImagePaths(obj.Object, "")
+}
+
+// walkImagePaths mirrors walkImage's recursive search for the well-known
+// "containers"/"initContainers" arrays, but also records the JSON Pointer
+// path of each match relative to prefix.
+func walkImagePaths(obj map[string]interface{}, prefix string) (matches []imagePathMatch) {
+	for k, v := range obj {
+		childPrefix := prefix + "/" + jsonPointerEscape(k)
+		if array, ok := v.([]interface{}); ok {
+			if k == "containers" || k == "initContainers" {
+				for i, elem := range array {
+					if mapObj, isMap := elem.(map[string]interface{}); isMap {
+						if image, isImage := mapObj["image"]; isImage {
+							matches = append(matches, imagePathMatch{
+								path:  fmt.Sprintf("%s/%d/image", childPrefix, i),
+								value: fmt.Sprintf("%s", image),
+							})
+						}
+					}
+				}
+			}
+		} else if objMap, ok := v.(map[string]interface{}); ok {
+			matches = append(matches, walkImagePaths(objMap, childPrefix)...)
+		}
+	}
+	return
+}
+
+// gvk identifies a resource kind by its apiVersion and kind, the same two
+// fields an extractor is keyed by.
+type gvk struct {
+	apiVersion string
+	kind       string
+}
+
+// extractorRegistry maps a GVK to the imageExtractor responsible for it.
+type extractorRegistry map[gvk]imageExtractor
+
+// defaultExtractorRegistry is seeded with extractors for the core workload
+// kinds plus a handful of common operator CRDs. Callers may extend it with
+// registerExtractorsFromConfig.
+var defaultExtractorRegistry = extractorRegistry{
+	{"v1", "Pod"}: jsonPathExtractor{
+		"spec.containers[*].image",
+		"spec.initContainers[*].image",
+		"spec.ephemeralContainers[*].image",
+	},
+	{"apps/v1", "Deployment"}:  podTemplateExtractor,
+	{"apps/v1", "StatefulSet"}: podTemplateExtractor,
+	{"apps/v1", "DaemonSet"}:   podTemplateExtractor,
+	{"apps/v1", "ReplicaSet"}:  podTemplateExtractor,
+	{"batch/v1", "Job"}:        jobTemplateExtractor,
+	{"batch/v1", "CronJob"}: jsonPathExtractor{
+		"spec.jobTemplate.spec.template.spec.containers[*].image",
+		"spec.jobTemplate.spec.template.spec.initContainers[*].image",
+		"spec.jobTemplate.spec.template.spec.ephemeralContainers[*].image",
+	},
+	// ArgoCD Application: the image is usually expressed as a Helm parameter
+	// or kustomize image override rather than a single field, but many
+	// repos pin a literal image via an "image"-named Helm parameter.
+	{"argoproj.io/v1alpha1", "Application"}: argoHelmParamsExtractor{},
+	// Tekton Task/Pipeline steps.
+	{"tekton.dev/v1beta1", "Task"}: jsonPathExtractor{
+		"spec.steps[*].image",
+		"spec.stepTemplate.image",
+	},
+	{"tekton.dev/v1beta1", "Pipeline"}: jsonPathExtractor{
+		"spec.tasks[*].taskSpec.steps[*].image",
+	},
+	// Flux HelmRelease doesn't carry a resolved image directly, but some
+	// users pin one via values.image.repository; only that field is read, and
+	// a separate values.image.tag is not appended to it.
+	{"helm.toolkit.fluxcd.io/v2beta1", "HelmRelease"}: jsonPathExtractor{
+		"spec.values.image.repository",
+	},
+	// KubeVirt VirtualMachine container disk images.
+	{"kubevirt.io/v1", "VirtualMachine"}: jsonPathExtractor{
+		"spec.template.spec.volumes[*].containerDisk.image",
+	},
+	// Prometheus Operator CRDs bury their image at a non-standard path.
+	{"monitoring.coreos.com/v1", "Prometheus"}: jsonPathExtractor{
+		"spec.image",
+	},
+	{"monitoring.coreos.com/v1", "Alertmanager"}: jsonPathExtractor{
+		"spec.image",
+	},
+}
+
+// podTemplateExtractor handles any resource whose pod template lives at
+// spec.template.spec, which covers Deployment, StatefulSet, DaemonSet and
+// ReplicaSet.
+var podTemplateExtractor = jsonPathExtractor{
+	"spec.template.spec.containers[*].image",
+	"spec.template.spec.initContainers[*].image",
+	"spec.template.spec.ephemeralContainers[*].image",
+}
+
+// jobTemplateExtractor handles batch/v1 Job, whose pod template also lives at
+// spec.template.spec.
+var jobTemplateExtractor = podTemplateExtractor
+
+// extractImages looks up a registered extractor for obj's GVK and returns the
+// images it finds, falling back (in order) to the skopeo.io/imagepaths
+// annotation, and finally to a generic recursive container-array walk.
+func extractImages(reg extractorRegistry, obj *unstructured.Unstructured) []string {
+	key := gvk{obj.GetAPIVersion(), obj.GetKind()}
+	if extractor, ok := reg[key]; ok {
+		return extractor.Extract(obj)
+	}
+
+	if paths, ok := obj.GetAnnotations()[imagePathsAnnotation]; ok {
+		return jsonPathExtractor(strings.Split(paths, ",")).Extract(obj)
+	}
+
+	return genericWalkExtractor{}.Extract(obj)
+}
+
+// extractImagePaths is extractImages' counterpart for --rewrite-output's JSON
+// Patch format: it looks up the same extractor extractImages would use, but
+// returns each match paired with the JSON Pointer path it was found at.
+func extractImagePaths(reg extractorRegistry, obj *unstructured.Unstructured) []imagePathMatch {
+	key := gvk{obj.GetAPIVersion(), obj.GetKind()}
+	if extractor, ok := reg[key]; ok {
+		return extractor.ExtractPaths(obj)
+	}
+
+	if paths, ok := obj.GetAnnotations()[imagePathsAnnotation]; ok {
+		return jsonPathExtractor(strings.Split(paths, ",")).ExtractPaths(obj)
+	}
+
+	return genericWalkExtractor{}.ExtractPaths(obj)
+}
+
+// rewriteImages looks up a registered extractor for obj's GVK (the same one
+// extractImages would use) and rewrites every image it finds in place via
+// rewrite, which maps an original image reference to its replacement.
+func rewriteImages(reg extractorRegistry, obj *unstructured.Unstructured, rewrite func(image string) string) {
+	key := gvk{obj.GetAPIVersion(), obj.GetKind()}
+	if extractor, ok := reg[key]; ok {
+		extractor.Rewrite(obj, rewrite)
+		return
+	}
+
+	if paths, ok := obj.GetAnnotations()[imagePathsAnnotation]; ok {
+		jsonPathExtractor(strings.Split(paths, ",")).Rewrite(obj, rewrite)
+		return
+	}
+
+	genericWalkExtractor{}.Rewrite(obj, rewrite)
+}
+
+// evalImagePath resolves a dotted, JSONPath-like expression such as
+// "spec.template.spec.containers[*].image" against obj, returning every
+// string value the expression matches. A "[*]" suffix on a path segment
+// iterates over that segment's array, applying the remaining path to each
+// element; a plain segment name just descends into a map.
+func evalImagePath(obj map[string]interface{}, path string) []string {
+	return evalImagePathSegments(obj, strings.Split(path, "."))
+}
+
+func evalImagePathSegments(node interface{}, segments []string) (results []string) {
+	if len(segments) == 0 {
+		if s, ok := node.(string); ok {
+			results = append(results, s)
+		} else if node != nil {
+			results = append(results, fmt.Sprintf("%v", node))
+		}
+		return
+	}
+
+	segment := segments[0]
+	rest := segments[1:]
+
+	name := segment
+	wantsArray := false
+	if strings.HasSuffix(segment, "[*]") {
+		name = strings.TrimSuffix(segment, "[*]")
+		wantsArray = true
+	}
+
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	child, present := m[name]
+	if !present {
+		return nil
+	}
+
+	if wantsArray {
+		array, ok := child.([]interface{})
+		if !ok {
+			return nil
+		}
+		for _, elem := range array {
+			results = append(results, evalImagePathSegments(elem, rest)...)
+		}
+		return
+	}
+
+	return evalImagePathSegments(child, rest)
+}
+
+// evalImagePathPointers is evalImagePath's counterpart for --rewrite-output's
+// JSON Patch format: it resolves the same dotted expression against obj, but
+// returns each match paired with the RFC 6901 JSON Pointer path it was found
+// at (e.g. "/spec/containers/0/image") instead of just the value.
+func evalImagePathPointers(obj map[string]interface{}, path string) []imagePathMatch {
+	return evalImagePathSegmentsPointers(obj, strings.Split(path, "."), "")
+}
+
+func evalImagePathSegmentsPointers(node interface{}, segments []string, prefix string) (matches []imagePathMatch) {
+	if len(segments) == 0 {
+		if s, ok := node.(string); ok {
+			matches = append(matches, imagePathMatch{path: prefix, value: s})
+		} else if node != nil {
+			matches = append(matches, imagePathMatch{path: prefix, value: fmt.Sprintf("%v", node)})
+		}
+		return
+	}
+
+	segment := segments[0]
+	rest := segments[1:]
+
+	name := segment
+	wantsArray := false
+	if strings.HasSuffix(segment, "[*]") {
+		name = strings.TrimSuffix(segment, "[*]")
+		wantsArray = true
+	}
+
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	child, present := m[name]
+	if !present {
+		return nil
+	}
+	childPrefix := prefix + "/" + jsonPointerEscape(name)
+
+	if wantsArray {
+		array, ok := child.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, elem := range array {
+			matches = append(matches, evalImagePathSegmentsPointers(elem, rest, fmt.Sprintf("%s/%d", childPrefix, i))...)
+		}
+		return
+	}
+
+	return evalImagePathSegmentsPointers(child, rest, childPrefix)
+}
+
+// jsonPointerEscape escapes a single JSON object key per RFC 6901 so it can
+// be embedded as one segment of a JSON Pointer path.
+func jsonPointerEscape(key string) string {
+	key = strings.ReplaceAll(key, "~", "~0")
+	key = strings.ReplaceAll(key, "/", "~1")
+	return key
+}
+
+// setImagePath applies rewrite to every string value that path (the same
+// dotted, "[*]"-capable expression evalImagePath understands) resolves to
+// within obj, replacing it in place.
+func setImagePath(obj map[string]interface{}, path string, rewrite func(image string) string) {
+	setImagePathSegments(obj, strings.Split(path, "."), rewrite)
+}
+
+func setImagePathSegments(node interface{}, segments []string, rewrite func(image string) string) {
+	if len(segments) != 1 {
+		segment := segments[0]
+		name := strings.TrimSuffix(segment, "[*]")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return
+		}
+		child, present := m[name]
+		if !present {
+			return
+		}
+		if strings.HasSuffix(segment, "[*]") {
+			array, ok := child.([]interface{})
+			if !ok {
+				return
+			}
+			for _, elem := range array {
+				setImagePathSegments(elem, segments[1:], rewrite)
+			}
+			return
+		}
+		setImagePathSegments(child, segments[1:], rewrite)
+		return
+	}
+
+	// Last segment: node must be the map holding the leaf field itself.
+	name := strings.TrimSuffix(segments[0], "[*]")
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if current, present := m[name]; present {
+		if s, ok := current.(string); ok {
+			m[name] = rewrite(s)
+		} else if array, ok := current.([]interface{}); ok && strings.HasSuffix(segments[0], "[*]") {
+			for i, elem := range array {
+				if s, ok := elem.(string); ok {
+					array[i] = rewrite(s)
+				}
+			}
+		}
+	}
+}
+
+// rewriteWalkImage mirrors walkImage's recursive search for the well-known
+// "containers"/"initContainers" arrays, but rewrites each "image" field in
+// place via rewrite instead of collecting it.
+func rewriteWalkImage(obj map[string]interface{}, rewrite func(image string) string) {
+	for k, v := range obj {
+		if array, ok := v.([]interface{}); ok {
+			if k == "containers" || k == "initContainers" {
+				for _, elem := range array {
+					if mapObj, isMap := elem.(map[string]interface{}); isMap {
+						if image, isImage := mapObj["image"].(string); isImage {
+							mapObj["image"] = rewrite(image)
+						}
+					}
+				}
+			}
+		} else if objMap, ok := v.(map[string]interface{}); ok {
+			rewriteWalkImage(objMap, rewrite)
+		}
+	}
+}
+
+// extractorConfigEntry describes a single user-registered extractor: the GVK
+// it applies to, and the JSONPath-like expressions that locate its images.
+type extractorConfigEntry struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Paths      []string `json:"paths"`
+}
+
+// extractorConfigFile is the top-level shape of the --extractor-config YAML
+// file, letting users register extractors for arbitrary CRDs by GVK and
+// JSONPath without recompiling skopeo.
+type extractorConfigFile struct {
+	Extractors []extractorConfigEntry `json:"extractors"`
+}
+
+// loadExtractorConfig reads and parses an extractor config file from path.
+func loadExtractorConfig(path string) (*extractorConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading extractor config %q", path)
+	}
+	var cfg extractorConfigFile
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, errors.Wrapf(err, "parsing extractor config %q", path)
+	}
+	for i, entry := range cfg.Extractors {
+		if entry.APIVersion == "" || entry.Kind == "" {
+			return nil, fmt.Errorf("extractor config entry %d is missing apiVersion or kind", i)
+		}
+		if len(entry.Paths) == 0 {
+			return nil, fmt.Errorf("extractor config entry %d (%s/%s) has no paths", i, entry.APIVersion, entry.Kind)
+		}
+	}
+	return &cfg, nil
+}
+
+// registerExtractorsFromConfig adds (or overrides) entries in reg for every
+// extractor described by cfg.
+func registerExtractorsFromConfig(reg extractorRegistry, cfg *extractorConfigFile) {
+	for _, entry := range cfg.Extractors {
+		reg[gvk{entry.APIVersion, entry.Kind}] = jsonPathExtractor(entry.Paths)
+	}
+}
+
+// newExtractorRegistry returns a copy of defaultExtractorRegistry, optionally
+// merging in user-defined extractors loaded from configPath.
+func newExtractorRegistry(configPath string) (extractorRegistry, error) {
+	reg := make(extractorRegistry, len(defaultExtractorRegistry))
+	for k, v := range defaultExtractorRegistry {
+		reg[k] = v
+	}
+	if configPath == "" {
+		return reg, nil
+	}
+	cfg, err := loadExtractorConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	registerExtractorsFromConfig(reg, cfg)
+	return reg, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestEvalImagePath(t *testing.T) {
+	obj := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"image": "nginx:1.21"},
+						map[string]interface{}{"image": "redis:7"},
+					},
+				},
+			},
+		},
+	}
+
+	got := evalImagePath(obj, "spec.template.spec.containers[*].image")
+	want := []string{"nginx:1.21", "redis:7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("evalImagePath() = %v, want %v", got, want)
+	}
+}
+
+func TestEvalImagePathMissingSegment(t *testing.T) {
+	obj := map[string]interface{}{"spec": map[string]interface{}{}}
+	if got := evalImagePath(obj, "spec.template.spec.containers[*].image"); got != nil {
+		t.Errorf("evalImagePath() = %v, want nil", got)
+	}
+}
+
+func TestSetImagePath(t *testing.T) {
+	obj := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{"image": "nginx:1.21"},
+			},
+		},
+	}
+
+	setImagePath(obj, "spec.containers[*].image", func(image string) string {
+		return "my.registry/" + image
+	})
+
+	containers := obj["spec"].(map[string]interface{})["containers"].([]interface{})
+	got := containers[0].(map[string]interface{})["image"]
+	if got != "my.registry/nginx:1.21" {
+		t.Errorf("setImagePath() left image = %v, want my.registry/nginx:1.21", got)
+	}
+}
+
+func TestArgoHelmParamsExtractorOnlyMatchesImageParams(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"source": map[string]interface{}{
+				"helm": map[string]interface{}{
+					"parameters": []interface{}{
+						map[string]interface{}{"name": "image.repository", "value": "nginx"},
+						map[string]interface{}{"name": "replicaCount", "value": "3"},
+						map[string]interface{}{"name": "worker.image", "value": "redis:7"},
+					},
+				},
+			},
+		},
+	}}
+
+	got := argoHelmParamsExtractor{}.Extract(obj)
+	want := []string{"nginx", "redis:7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Extract() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/containers/image/v5/types"
+	enchelpers "github.com/containers/ocicrypt/helpers"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/log"
+	"github.com/urfave/cli"
+)
+
+// mirrorConcurrency is the default number of images mirrored at once; it
+// mirrors the podman/skopeo convention of small, fixed worker pools rather
+// than an unbounded fan-out against registries.
+const mirrorConcurrency = 4
+
+// mirrorResult records what happened when copying a single image discovered
+// in the input manifests, for inclusion in the --output-file JSON summary.
+type mirrorResult struct {
+	Source            string `json:"source"`
+	Destination       string `json:"destination"`
+	DestinationDigest string `json:"destinationDigest,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// mirrorManifestsOptions collects CLI flags for "skopeo mirror-manifests".
+type mirrorManifestsOptions struct {
+	global          *globalOptions
+	shared          *sharedImageOptions
+	srcImage        *imageOptions
+	destImage       *imageDestOptions
+	manifestSrc     *manifestSourceOptions
+	shortName       *shortNameOptions
+	rewrite         *rewriteOutputOptions
+	extractorConfig string // --extractor-config
+	platforms       string // --platforms
+	retries         int    // --retries
+	outputFile      string // --output-file
+
+	encryptionKeys      cli.StringSlice // --encryption-key, repeatable
+	decryptionKeys      cli.StringSlice // --decryption-key, repeatable
+	signBy              string          // --sign-by
+	signPassphraseFile  string          // --sign-passphrase-file
+	signaturePolicyPath string          // --signature-policy
+}
+
+// mirrorManifestsCmd returns the "mirror-manifests" subcommand.
+func mirrorManifestsCmd(global *globalOptions) cli.Command {
+	sharedFlags, sharedOpts := sharedImageFlags()
+	srcFlags, srcOpts := imageFlags(global, sharedOpts, "src-", "screds")
+	destFlags, destOpts := imageDestFlags(global, sharedOpts, "dest-", "dcreds")
+	manifestFlags, manifestOpts := manifestSourceFlags()
+	shortNameFlagsList, shortNameOpts := shortNameFlags()
+	rewriteFlagsList, rewriteOpts := rewriteOutputFlags()
+
+	opts := mirrorManifestsOptions{
+		global:      global,
+		shared:      sharedOpts,
+		srcImage:    srcOpts,
+		destImage:   destOpts,
+		manifestSrc: manifestOpts,
+		shortName:   shortNameOpts,
+		rewrite:     rewriteOpts,
+	}
+
+	return cli.Command{
+		Name:      "mirror-manifests",
+		Usage:     "Copy every image referenced by a Kubernetes manifests file, Helm chart, or Kustomize overlay to DESTINATION-PREFIX",
+		ArgsUsage: "MANIFESTS-PATH DESTINATION-PREFIX",
+		Flags: append(append(append(append(append(append(sharedFlags, srcFlags...), destFlags...), manifestFlags...), shortNameFlagsList...), rewriteFlagsList...),
+			cli.StringFlag{
+				Name:        "extractor-config",
+				Usage:       "`FILE` registering additional image extractors for custom resource kinds",
+				Destination: &opts.extractorConfig,
+			},
+			cli.StringFlag{
+				Name:        "platforms",
+				Usage:       "copy only the `PLATFORMS` (comma-separated os/arch[/variant], e.g. linux/amd64,linux/arm64) of any source manifest lists",
+				Destination: &opts.platforms,
+			},
+			cli.IntFlag{
+				Name:        "retries",
+				Usage:       "number of times to retry a failed copy",
+				Value:       3,
+				Destination: &opts.retries,
+			},
+			cli.StringFlag{
+				Name:        "output-file",
+				Usage:       "write a JSON summary mapping each source reference to its resolved destination digest to `FILE`",
+				Destination: &opts.outputFile,
+			},
+			cli.StringSliceFlag{
+				Name:  "encryption-key",
+				Usage: "encrypt layers with the provided `KEY-SPEC` (e.g. jwe:/path/to/pub.pem); may be repeated",
+				Value: &opts.encryptionKeys,
+			},
+			cli.StringSliceFlag{
+				Name:  "decryption-key",
+				Usage: "decrypt layers with the provided `KEY-SPEC`; may be repeated",
+				Value: &opts.decryptionKeys,
+			},
+			cli.StringFlag{
+				Name:        "sign-by",
+				Usage:       "sign the destination image with the GPG key identified by `FINGERPRINT`",
+				Destination: &opts.signBy,
+			},
+			cli.StringFlag{
+				Name:        "sign-passphrase-file",
+				Usage:       "read the passphrase for --sign-by from `FILE` instead of prompting",
+				Destination: &opts.signPassphraseFile,
+			},
+			cli.StringFlag{
+				Name:        "signature-policy",
+				Usage:       "`PATH` to a signature verification policy file to use for both source and destination",
+				Destination: &opts.signaturePolicyPath,
+			},
+		),
+		Action: commandAction(opts.run),
+	}
+}
+
+func (opts *mirrorManifestsOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 2 {
+		return errorShouldDisplayUsage{errors.New("exactly two arguments expected: MANIFESTS-PATH and DESTINATION-PREFIX")}
+	}
+	manifestsPath := args[0]
+	destPrefix := args[1]
+
+	reg, err := newExtractorRegistry(opts.extractorConfig)
+	if err != nil {
+		return err
+	}
+
+	rawYAML, err := resolveManifestSource(manifestsPath, opts.manifestSrc)
+	if err != nil {
+		return err
+	}
+
+	objs := unmarshalUnstructuredK8s(rawYAML)
+	rawImages := imagesFromManifestObjs(reg, objs)
+	if len(rawImages) == 0 {
+		log.Infof("No images found in %s", manifestsPath)
+		return nil
+	}
+
+	srcSys, err := opts.srcImage.newSystemContext()
+	if err != nil {
+		return err
+	}
+	// resolvedImages is what's actually copied; rawImages (same order, same
+	// length) is what literally appears in the manifests, and is what
+	// --rewrite-output must match against.
+	resolvedImages, err := resolveShortNames(rawImages, opts.shortName, srcSys)
+	if err != nil {
+		return err
+	}
+
+	var platforms []imgspecPlatform
+	if opts.platforms != "" {
+		platforms, err = parsePlatforms(opts.platforms)
+		if err != nil {
+			return err
+		}
+	}
+
+	registryMap, err := parseRegistryMap([]string(opts.rewrite.registryMap))
+	if err != nil {
+		return err
+	}
+
+	newPolicyContext, err := opts.policyContextFactory()
+	if err != nil {
+		return errors.Wrap(err, "loading trust policy")
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make([]mirrorResult, len(resolvedImages))
+		sem     = make(chan struct{}, mirrorConcurrency)
+	)
+
+	for i, srcName := range resolvedImages {
+		wg.Add(1)
+		go func(i int, srcName, rawName string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			destName := mirrorDestination(rawName, srcName, destPrefix, registryMap)
+			result := mirrorResult{Source: rawName, Destination: destName}
+
+			digest, err := opts.mirrorOneWithRetries(newPolicyContext, srcName, destName, platforms)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.DestinationDigest = digest
+			}
+
+			mu.Lock()
+			results[i] = result
+			mu.Unlock()
+		}(i, srcName, rawImages[i])
+	}
+	wg.Wait()
+
+	failures := 0
+	for _, r := range results {
+		if r.Error != "" {
+			failures++
+			fmt.Fprintf(stdout, "FAILED  %s -> %s: %s\n", r.Source, r.Destination, r.Error)
+		} else {
+			fmt.Fprintf(stdout, "OK      %s -> %s@%s\n", r.Source, r.Destination, r.DestinationDigest)
+		}
+	}
+
+	if opts.outputFile != "" {
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshaling mirror summary")
+		}
+		if err := os.WriteFile(opts.outputFile, out, 0644); err != nil {
+			return errors.Wrapf(err, "writing mirror summary to %q", opts.outputFile)
+		}
+	}
+
+	if opts.rewrite.path != "" {
+		byOldImage := buildRewriteMap(results)
+		if err := writeRewriteOutput(opts.rewrite, objs, reg, byOldImage); err != nil {
+			return errors.Wrapf(err, "writing rewrite output to %q", opts.rewrite.path)
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d images failed to mirror", failures, len(resolvedImages))
+	}
+	return nil
+}
+
+// policyContextFactory returns a function that builds a fresh
+// signature.PolicyContext on every call. PolicyContext keeps internal,
+// unsynchronized state that copy.Image mutates on every copy, so it must
+// never be shared across the concurrent goroutines in run() — each copy
+// needs its own. When --signature-policy is set, the (immutable)
+// signature.Policy itself is loaded only once, so concurrent workers don't
+// each re-read and re-parse the policy file; the default policy path already
+// caches equivalently via opts.global.getPolicyContext.
+func (opts *mirrorManifestsOptions) policyContextFactory() (func() (*signature.PolicyContext, error), error) {
+	if opts.signaturePolicyPath == "" {
+		return opts.global.getPolicyContext, nil
+	}
+	policy, err := signature.NewPolicyFromFile(opts.signaturePolicyPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading signature policy %q", opts.signaturePolicyPath)
+	}
+	return func() (*signature.PolicyContext, error) {
+		return signature.NewPolicyContext(policy)
+	}, nil
+}
+
+// getPassphraseFromFile reads a GPG signing passphrase from path, trimming
+// the trailing newline a user's editor is likely to have added.
+func getPassphraseFromFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading signing passphrase from %q", path)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// mirrorOneWithRetries copies a single image from srcName to destName,
+// retrying up to opts.retries times, and returns the destination's resolved
+// manifest digest on success.
+func (opts *mirrorManifestsOptions) mirrorOneWithRetries(newPolicyContext func() (*signature.PolicyContext, error), srcName, destName string, platforms []imgspecPlatform) (string, error) {
+	var lastErr error
+	for attempt := 0; attempt <= opts.retries; attempt++ {
+		digest, err := opts.mirrorOne(newPolicyContext, srcName, destName, platforms)
+		if err == nil {
+			return digest, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+func (opts *mirrorManifestsOptions) mirrorOne(newPolicyContext func() (*signature.PolicyContext, error), srcName, destName string, platforms []imgspecPlatform) (string, error) {
+	ctx := context.Background()
+
+	policyContext, err := newPolicyContext()
+	if err != nil {
+		return "", errors.Wrap(err, "loading trust policy")
+	}
+	defer policyContext.Destroy()
+
+	srcRef, err := alltransports.ParseImageName(srcName)
+	if err != nil {
+		// Bare names from manifests (e.g. "nginx") aren't resolvable by
+		// ParseImageName directly; assume the docker transport.
+		srcRef, err = alltransports.ParseImageName("docker://" + srcName)
+		if err != nil {
+			return "", errors.Wrapf(err, "parsing source image %q", srcName)
+		}
+	}
+	destRef, err := alltransports.ParseImageName("docker://" + destName)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing destination image %q", destName)
+	}
+
+	srcCtx, err := opts.srcImage.newSystemContext()
+	if err != nil {
+		return "", err
+	}
+	destCtx, err := opts.destImage.newSystemContext()
+	if err != nil {
+		return "", err
+	}
+
+	copyOptions := &copy.Options{
+		SourceCtx:      srcCtx,
+		DestinationCtx: destCtx,
+	}
+
+	if opts.signBy != "" {
+		copyOptions.SignBy = opts.signBy
+		if opts.signPassphraseFile != "" {
+			passphrase, err := getPassphraseFromFile(opts.signPassphraseFile)
+			if err != nil {
+				return "", err
+			}
+			copyOptions.SignPassphrase = passphrase
+		}
+	}
+
+	encryptionKeys := []string(opts.encryptionKeys)
+	decryptionKeys := []string(opts.decryptionKeys)
+	if len(encryptionKeys) > 0 || len(decryptionKeys) > 0 {
+		cc, err := enchelpers.CreateCryptoConfig(encryptionKeys, decryptionKeys)
+		if err != nil {
+			return "", errors.Wrap(err, "setting up encryption/decryption keys")
+		}
+		copyOptions.OciEncryptConfig = cc.EncryptConfig
+		copyOptions.OciDecryptConfig = cc.DecryptConfig
+	}
+
+	if len(platforms) > 0 {
+		instances, err := selectInstances(ctx, srcRef, srcCtx, platforms)
+		if err != nil {
+			return "", err
+		}
+		if len(instances) > 0 {
+			copyOptions.ImageListSelection = copy.CopySpecificImages
+			copyOptions.Instances = instances
+		}
+	}
+
+	manifestBytes, err := copy.Image(ctx, policyContext, destRef, srcRef, copyOptions)
+	if err != nil {
+		return "", errors.Wrapf(err, "copying %q to %q", srcName, destName)
+	}
+
+	digest, err := manifest.Digest(manifestBytes)
+	if err != nil {
+		return "", errors.Wrap(err, "computing destination digest")
+	}
+	return digest.String(), nil
+}
+
+// imgspecPlatform is the (os, arch, variant) triple used to select manifest
+// list instances, mirroring podman's --override-arch/--override-os semantics.
+type imgspecPlatform struct {
+	os      string
+	arch    string
+	variant string
+}
+
+// parsePlatforms parses a comma-separated "--platforms" value such as
+// "linux/amd64,linux/arm64/v7" into a slice of imgspecPlatform.
+func parsePlatforms(s string) ([]imgspecPlatform, error) {
+	var platforms []imgspecPlatform
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), "/")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", entry)
+		}
+		p := imgspecPlatform{os: parts[0], arch: parts[1]}
+		if len(parts) == 3 {
+			p.variant = parts[2]
+		}
+		platforms = append(platforms, p)
+	}
+	return platforms, nil
+}
+
+// selectInstances inspects srcRef and, if it resolves to a manifest list,
+// returns the digests of the instances matching platforms so the caller can
+// restrict the copy to them while still preserving the list at the destination.
+func selectInstances(ctx context.Context, srcRef types.ImageReference, srcCtx *types.SystemContext, platforms []imgspecPlatform) ([]digest.Digest, error) {
+	src, err := srcRef.NewImageSource(ctx, srcCtx)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening source image")
+	}
+	defer src.Close()
+
+	rawManifest, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading source manifest")
+	}
+	if !manifest.MIMETypeIsMultiImage(mimeType) {
+		// Not a manifest list: nothing to select, caller copies as-is.
+		return nil, nil
+	}
+
+	var instances []digest.Digest
+	switch mimeType {
+	case manifest.DockerV2ListMediaType:
+		list, err := manifest.Schema2ListFromManifest(rawManifest)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing Docker manifest list")
+		}
+		for _, m := range list.Manifests {
+			if m.Platform.OS == "" {
+				continue
+			}
+			if platformMatches(m.Platform.OS, m.Platform.Architecture, m.Platform.Variant, platforms) {
+				instances = append(instances, m.Digest)
+			}
+		}
+	default:
+		index, err := manifest.OCI1IndexFromManifest(rawManifest)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing OCI image index")
+		}
+		for _, m := range index.Manifests {
+			if m.Platform == nil {
+				continue
+			}
+			if platformMatches(m.Platform.OS, m.Platform.Architecture, m.Platform.Variant, platforms) {
+				instances = append(instances, m.Digest)
+			}
+		}
+	}
+
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no manifest list instance matches the requested platforms (%v)", platforms)
+	}
+	return instances, nil
+}
+
+func platformMatches(os, arch, variant string, platforms []imgspecPlatform) bool {
+	for _, p := range platforms {
+		if os == p.os && arch == p.arch && (p.variant == "" || variant == p.variant) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteImageToPrefix rewrites a source image reference to live under
+// destPrefix, keeping the original repository path and tag/digest.
+func rewriteImageToPrefix(srcName, destPrefix string) string {
+	name := srcName
+	name = strings.TrimPrefix(name, "docker://")
+
+	ref := name
+	if idx := strings.IndexAny(ref, "@"); idx != -1 {
+		ref = ref[:idx]
+	} else if idx := strings.LastIndex(ref, ":"); idx != -1 && !strings.Contains(ref[idx:], "/") {
+		ref = ref[:idx]
+	}
+
+	// Strip the leading registry host (anything before the first "/" that
+	// looks like a host, i.e. contains a "." or ":") so DESTINATION-PREFIX
+	// fully controls where the image lands.
+	repoPath := ref
+	if slash := strings.Index(repoPath, "/"); slash != -1 {
+		host := repoPath[:slash]
+		if strings.ContainsAny(host, ".:") {
+			repoPath = repoPath[slash+1:]
+		}
+	}
+
+	suffix := strings.TrimPrefix(name, ref)
+	return path.Join(destPrefix, repoPath) + suffix
+}
+
+// mirrorDestination computes where rawName (the reference as it literally
+// appears in the source manifests) should be copied to: registryMap's
+// longest matching prefix rewrite if one is configured and matches,
+// otherwise the flat destPrefix. rawName (not the short-name-resolved
+// srcName) is used for matching so --registry-map entries like
+// "docker.io/library=..." line up with what's actually written in the
+// manifests.
+func mirrorDestination(rawName, srcName, destPrefix string, registryMap []registryMapEntry) string {
+	if len(registryMap) > 0 {
+		if remapped, ok := applyRegistryMap(rawName, registryMap); ok {
+			return remapped
+		}
+	}
+	return rewriteImageToPrefix(srcName, destPrefix)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// registryMapEntry is one "old=new" pair from a repeated --registry-map flag,
+// expressing a prefix-level rewrite such as
+// "docker.io/library=my.registry/proxy/library".
+type registryMapEntry struct {
+	oldPrefix string
+	newPrefix string
+}
+
+// parseRegistryMap parses the raw "old=new" values collected by --registry-map.
+func parseRegistryMap(entries []string) ([]registryMapEntry, error) {
+	var out []registryMapEntry
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --registry-map %q: expected old=new", entry)
+		}
+		out = append(out, registryMapEntry{oldPrefix: parts[0], newPrefix: parts[1]})
+	}
+	return out, nil
+}
+
+// applyRegistryMap rewrites the registry/repository prefix of image using the
+// longest matching entry in m, leaving the tag or digest suffix untouched.
+// The second return value is false if no entry matched.
+func applyRegistryMap(image string, m []registryMapEntry) (string, bool) {
+	best := -1
+	var bestEntry registryMapEntry
+	for _, entry := range m {
+		if strings.HasPrefix(image, entry.oldPrefix) && len(entry.oldPrefix) > best {
+			best = len(entry.oldPrefix)
+			bestEntry = entry
+		}
+	}
+	if best == -1 {
+		return image, false
+	}
+	return bestEntry.newPrefix + strings.TrimPrefix(image, bestEntry.oldPrefix), true
+}
+
+// rewriteOutputOptions collects the CLI flags controlling --rewrite-output.
+type rewriteOutputOptions struct {
+	path        string          // --rewrite-output
+	registryMap cli.StringSlice // --registry-map, repeatable
+}
+
+// rewriteOutputFlags prepares a collection of CLI flags writing into
+// rewriteOutputOptions, and the managed rewriteOutputOptions structure.
+func rewriteOutputFlags() ([]cli.Flag, *rewriteOutputOptions) {
+	opts := rewriteOutputOptions{}
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:        "rewrite-output",
+			Usage:       "write the input manifests with images rewritten to their mirrored references to `FILE`; .json produces a JSON Patch, a filename containing \"kustomization\" produces a Kustomize images: block, anything else produces rewritten multi-doc YAML",
+			Destination: &opts.path,
+		},
+		cli.StringSliceFlag{
+			Name:  "registry-map",
+			Usage: "copy (and, in --rewrite-output, rewrite) images under `OLD=NEW` registry/repository prefixes to the NEW prefix instead of the flat DESTINATION-PREFIX; may be repeated",
+			Value: &opts.registryMap,
+		},
+	}, &opts
+}
+
+// buildRewriteMap turns the per-image results of a mirror run into a map from
+// original (source) image reference to the reference manifests should be
+// rewritten to use, pinning the destination digest when one is known.
+// r.Destination already reflects --registry-map (see mirrorDestination), so
+// manifests are only ever rewritten to point at where the copy actually went.
+func buildRewriteMap(results []mirrorResult) map[string]string {
+	out := make(map[string]string, len(results))
+	for _, r := range results {
+		if r.Error != "" {
+			continue
+		}
+		dest := r.Destination
+		if r.DestinationDigest != "" {
+			dest = stripImageTag(dest)
+			if at := strings.Index(dest, "@"); at != -1 {
+				dest = dest[:at]
+			}
+			dest += "@" + r.DestinationDigest
+		}
+		out[r.Source] = dest
+	}
+	return out
+}
+
+// stripImageTag drops a trailing ":tag" from image, leaving a bare
+// "@digest"-pinned reference behind. It is a no-op if image has no tag.
+func stripImageTag(image string) string {
+	name, digestSuffix := image, ""
+	if at := strings.Index(image, "@"); at != -1 {
+		name, digestSuffix = image[:at], image[at:]
+	}
+	if idx := strings.LastIndex(name, ":"); idx != -1 && !strings.Contains(name[idx:], "/") {
+		return name[:idx] + digestSuffix
+	}
+	return image
+}
+
+// writeRewriteOutput renders the requested rewrite format to opts.path, based
+// on objs (the same unstructured tree imagesFromManifestObjs walked) and
+// byOldImage (the source-image -> destination-image mapping to apply).
+func writeRewriteOutput(opts *rewriteOutputOptions, objs []*unstructured.Unstructured, reg extractorRegistry, byOldImage map[string]string) error {
+	rewrite := func(image string) string {
+		if dest, ok := byOldImage[image]; ok {
+			return dest
+		}
+		return image
+	}
+
+	switch {
+	case strings.HasSuffix(opts.path, ".json"):
+		return writeJSONPatch(opts.path, objs, reg, rewrite)
+	case strings.Contains(strings.ToLower(opts.path), "kustomization"):
+		return writeKustomizeImages(opts.path, byOldImage)
+	default:
+		return writeRewrittenYAML(opts.path, objs, reg, rewrite)
+	}
+}
+
+// jsonPatchOp is one RFC 6902 JSON Patch operation, scoped to a document
+// index since --rewrite-output's input is a multi-document YAML stream.
+type jsonPatchOp struct {
+	Doc   int         `json:"doc"`
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// writeJSONPatch walks every object's images via the extractor registry and
+// emits one "replace" operation per changed image field.
+func writeJSONPatch(path string, objs []*unstructured.Unstructured, reg extractorRegistry, rewrite func(string) string) error {
+	var ops []jsonPatchOp
+	for docIdx, obj := range objs {
+		before := extractImagePaths(reg, obj)
+		rewriteImages(reg, obj, rewrite)
+		after := extractImagePaths(reg, obj)
+
+		// genericWalkExtractor ranges over a map[string]interface{}, whose
+		// iteration order Go does not guarantee is stable across separate
+		// calls, so before/after must be paired up by JSON Pointer path
+		// rather than by slice index.
+		afterByPath := make(map[string]string, len(after))
+		for _, a := range after {
+			afterByPath[a.path] = a.value
+		}
+		for _, b := range before {
+			if newValue, ok := afterByPath[b.path]; ok && newValue != b.value {
+				ops = append(ops, jsonPatchOp{Doc: docIdx, Op: "replace", Path: b.path, Value: newValue})
+			}
+		}
+		for k, v := range obj.GetAnnotations() {
+			if k == extraImageAnnotation {
+				rewritten := rewriteAnnotationValue(v, rewrite)
+				if rewritten != v {
+					ops = append(ops, jsonPatchOp{Doc: docIdx, Op: "replace", Path: "/metadata/annotations/" + jsonPointerEscape(extraImageAnnotation), Value: rewritten})
+				}
+			}
+		}
+	}
+
+	out, err := json.MarshalIndent(ops, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshaling JSON Patch")
+	}
+	return os.WriteFile(path, out, 0644)
+}
+
+// writeKustomizeImages writes a Kustomize "images:" override block mapping
+// each original repository to its mirrored name and digest.
+func writeKustomizeImages(path string, byOldImage map[string]string) error {
+	var b strings.Builder
+	b.WriteString("images:\n")
+	for oldImage, newImage := range byOldImage {
+		name, newName, digest := splitImageForKustomize(oldImage, newImage)
+		b.WriteString(fmt.Sprintf("  - name: %s\n", name))
+		b.WriteString(fmt.Sprintf("    newName: %s\n", newName))
+		if digest != "" {
+			b.WriteString(fmt.Sprintf("    digest: %s\n", digest))
+		}
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// splitImageForKustomize separates a rewritten reference into the
+// name/newName/digest triple Kustomize's images: override expects.
+func splitImageForKustomize(oldImage, newImage string) (name, newName, digest string) {
+	name = stripImageTag(oldImage)
+	newName = newImage
+	if idx := strings.Index(newImage, "@"); idx != -1 {
+		newName = newImage[:idx]
+		digest = newImage[idx+1:]
+	}
+	return
+}
+
+// writeRewrittenYAML walks every object via the extractor registry, rewrites
+// its images and skopeo.io/extraimages annotation in place, and writes the
+// resulting multi-document YAML stream to path.
+func writeRewrittenYAML(path string, objs []*unstructured.Unstructured, reg extractorRegistry, rewrite func(string) string) error {
+	var docs []string
+	for _, obj := range objs {
+		rewriteImages(reg, obj, rewrite)
+
+		annotations := obj.GetAnnotations()
+		if v, ok := annotations[extraImageAnnotation]; ok {
+			annotations[extraImageAnnotation] = rewriteAnnotationValue(v, rewrite)
+			obj.SetAnnotations(annotations)
+		}
+
+		data, err := yamlMarshalUnstructured(obj)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, data)
+	}
+	return os.WriteFile(path, []byte(strings.Join(docs, "---\n")), 0644)
+}
+
+// yamlMarshalUnstructured renders a single rewritten resource back to YAML.
+func yamlMarshalUnstructured(obj *unstructured.Unstructured) (string, error) {
+	data, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", errors.Wrapf(err, "marshaling %s/%s", obj.GetAPIVersion(), obj.GetKind())
+	}
+	return string(data), nil
+}
+
+// rewriteAnnotationValue rewrites each comma-separated image in a
+// skopeo.io/extraimages annotation value.
+func rewriteAnnotationValue(v string, rewrite func(string) string) string {
+	images := strings.Split(v, ",")
+	for i, image := range images {
+		images[i] = rewrite(image)
+	}
+	return strings.Join(images, ",")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestApplyRegistryMap(t *testing.T) {
+	m, err := parseRegistryMap([]string{"docker.io/library=my.registry/proxy/library"})
+	if err != nil {
+		t.Fatalf("parseRegistryMap() error: %v", err)
+	}
+
+	got, ok := applyRegistryMap("docker.io/library/nginx:1.21", m)
+	if !ok || got != "my.registry/proxy/library/nginx:1.21" {
+		t.Errorf("applyRegistryMap() = (%q, %v), want (my.registry/proxy/library/nginx:1.21, true)", got, ok)
+	}
+
+	if _, ok := applyRegistryMap("quay.io/other/image:1", m); ok {
+		t.Errorf("applyRegistryMap() matched an unrelated prefix")
+	}
+}
+
+func TestMirrorDestinationHonorsRegistryMap(t *testing.T) {
+	m, err := parseRegistryMap([]string{"docker.io/library=my.registry/proxy/library"})
+	if err != nil {
+		t.Fatalf("parseRegistryMap() error: %v", err)
+	}
+
+	got := mirrorDestination("docker.io/library/nginx:1.21", "docker.io/library/nginx:1.21", "mirror.example.com/repo", m)
+	want := "my.registry/proxy/library/nginx:1.21"
+	if got != want {
+		t.Errorf("mirrorDestination() = %q, want %q", got, want)
+	}
+
+	// No matching registryMap entry: falls back to the flat DESTINATION-PREFIX.
+	got = mirrorDestination("quay.io/other/image:1", "quay.io/other/image:1", "mirror.example.com/repo", m)
+	want = "mirror.example.com/repo/other/image:1"
+	if got != want {
+		t.Errorf("mirrorDestination() fallback = %q, want %q", got, want)
+	}
+}
+
+func TestBuildRewriteMapUsesActualDestination(t *testing.T) {
+	results := []mirrorResult{
+		{Source: "nginx", Destination: "my.registry/proxy/library/nginx:1.21", DestinationDigest: "sha256:abc"},
+		{Source: "broken", Destination: "mirror.example.com/broken", Error: "boom"},
+	}
+
+	got := buildRewriteMap(results)
+	want := "my.registry/proxy/library/nginx@sha256:abc"
+	if got["nginx"] != want {
+		t.Errorf("buildRewriteMap()[\"nginx\"] = %q, want %q", got["nginx"], want)
+	}
+	if _, ok := got["broken"]; ok {
+		t.Errorf("buildRewriteMap() should skip failed results")
+	}
+}
+
+func TestExtractImagePathsReturnsRealPath(t *testing.T) {
+	reg := newExtractorRegistryForTest()
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"image": "nginx:1.21"},
+					},
+				},
+			},
+		},
+	}}
+
+	before := extractImagePaths(reg, obj)
+	if len(before) != 1 {
+		t.Fatalf("extractImagePaths() returned %d matches, want 1", len(before))
+	}
+	wantPath := "/spec/template/spec/containers/0/image"
+	if before[0].path != wantPath {
+		t.Errorf("extractImagePaths()[0].path = %q, want %q", before[0].path, wantPath)
+	}
+	if before[0].value != "nginx:1.21" {
+		t.Errorf("extractImagePaths()[0].value = %q, want nginx:1.21", before[0].value)
+	}
+}
+
+// newExtractorRegistryForTest returns defaultExtractorRegistry without
+// touching any on-disk --extractor-config.
+func newExtractorRegistryForTest() extractorRegistry {
+	reg := make(extractorRegistry, len(defaultExtractorRegistry))
+	for k, v := range defaultExtractorRegistry {
+		reg[k] = v
+	}
+	return reg
+}
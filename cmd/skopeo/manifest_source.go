@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/engine"
+	"sigs.k8s.io/kustomize/api/filesys"
+	"sigs.k8s.io/kustomize/api/krusty"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// manifestSourceOptions collects CLI flags describing where the Kubernetes YAML
+// fed into the image extractor pipeline should come from: a plain YAML file, a
+// Helm chart, or a Kustomize overlay.
+type manifestSourceOptions struct {
+	helmValuesFiles cli.StringSlice // --helm-values, repeatable
+	helmSetValues   cli.StringSlice // --helm-set, repeatable
+	kustomize       bool            // --kustomize
+}
+
+// manifestSourceFlags prepares a collection of CLI flags writing into manifestSourceOptions,
+// and the managed manifestSourceOptions structure.
+func manifestSourceFlags() ([]cli.Flag, *manifestSourceOptions) {
+	opts := manifestSourceOptions{}
+	return []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "helm-values",
+			Usage: "additional Helm values `FILE` (can be specified multiple times)",
+			Value: &opts.helmValuesFiles,
+		},
+		cli.StringSliceFlag{
+			Name:  "helm-set",
+			Usage: "set additional Helm values on the command line (`key1=val1,key2=val2`)",
+			Value: &opts.helmSetValues,
+		},
+		cli.BoolFlag{
+			Name:        "kustomize",
+			Usage:       "treat PATH as a Kustomize overlay directory instead of a plain YAML file",
+			Destination: &opts.kustomize,
+		},
+	}, &opts
+}
+
+// isHelmChart returns true if path looks like the root of a Helm chart, i.e.
+// it contains a Chart.yaml and a templates/ directory.
+func isHelmChart(path string) bool {
+	if _, err := os.Stat(filepath.Join(path, "Chart.yaml")); err != nil {
+		return false
+	}
+	if info, err := os.Stat(filepath.Join(path, "templates")); err != nil || !info.IsDir() {
+		return false
+	}
+	return true
+}
+
+// isKustomization returns true if path looks like a Kustomize overlay, i.e. it
+// contains a kustomization.yaml (or .yml, or plain "Kustomization").
+func isKustomization(path string) bool {
+	for _, name := range []string{"kustomization.yaml", "kustomization.yml", "Kustomization"} {
+		if _, err := os.Stat(filepath.Join(path, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// renderHelmChart renders the Helm chart rooted at path using opts, returning
+// the resulting multi-document YAML stream in the same form as a pre-rendered
+// manifests file.
+func renderHelmChart(path string, opts *manifestSourceOptions) (string, error) {
+	chrt, err := loader.Load(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "loading Helm chart at %q", path)
+	}
+
+	valueOpts := &values.Options{
+		ValueFiles: opts.helmValuesFiles,
+		Values:     opts.helmSetValues,
+	}
+	vals, err := valueOpts.MergeValues(nil)
+	if err != nil {
+		return "", errors.Wrap(err, "merging Helm values")
+	}
+
+	if err := chartutil.ProcessDependencies(chrt, vals); err != nil {
+		return "", errors.Wrap(err, "processing chart dependencies")
+	}
+
+	releaseOpts := chartutil.ReleaseOptions{
+		Name:      chrt.Name(),
+		Namespace: "default",
+	}
+	renderVals, err := chartutil.ToRenderValues(chrt, vals, releaseOpts, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "computing Helm render values")
+	}
+
+	rendered, err := engine.Render(chrt, renderVals)
+	if err != nil {
+		return "", errors.Wrap(err, "rendering Helm chart")
+	}
+
+	var docs []string
+	for name, content := range rendered {
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		if !strings.HasSuffix(name, ".yaml") && !strings.HasSuffix(name, ".yml") {
+			continue
+		}
+		docs = append(docs, content)
+	}
+	return strings.Join(docs, "\n---\n"), nil
+}
+
+// renderKustomize runs Kustomize's in-process build against the overlay rooted
+// at path, returning the resulting multi-document YAML stream.
+func renderKustomize(path string) (string, error) {
+	kOpts := krusty.MakeDefaultOptions()
+	k := krusty.MakeKustomizer(kOpts)
+
+	fSys := filesys.MakeFsOnDisk()
+	resMap, err := k.Run(fSys, path)
+	if err != nil {
+		return "", errors.Wrapf(err, "running kustomize build on %q", path)
+	}
+
+	out, err := resMap.AsYaml()
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling kustomize output")
+	}
+	return string(out), nil
+}
+
+// resolveManifestSource produces the raw, multi-document YAML stream that should
+// be fed into unmarshalUnstructuredK8s, rendering path with Helm or Kustomize
+// first if opts or the contents of path indicate that it is a chart or overlay
+// rather than a plain manifests file.
+func resolveManifestSource(path string, opts *manifestSourceOptions) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading %q", path)
+	}
+
+	if opts.kustomize {
+		if !info.IsDir() {
+			return "", fmt.Errorf("--kustomize requires PATH (%q) to be a directory", path)
+		}
+		return renderKustomize(path)
+	}
+
+	if info.IsDir() {
+		if isHelmChart(path) {
+			return renderHelmChart(path, opts)
+		}
+		if isKustomization(path) {
+			return renderKustomize(path)
+		}
+		return "", fmt.Errorf("%q is a directory but is neither a Helm chart nor a Kustomize overlay", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading %q", path)
+	}
+	return string(data), nil
+}
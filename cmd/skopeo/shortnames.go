@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/pkg/shortnames"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/log"
+	"github.com/urfave/cli"
+)
+
+// shortNameMode controls how bare image names parsed out of manifests (e.g.
+// "nginx", "redis:7") are qualified into a fully-specified reference before
+// being handed to alltransports.ParseImageName.
+type shortNameMode string
+
+const (
+	shortNameModeEnforcing  shortNameMode = "enforcing"
+	shortNameModePermissive shortNameMode = "permissive"
+	shortNameModeDockerHub  shortNameMode = "docker-hub"
+)
+
+// shortNameOptions collects the CLI flags controlling short-name resolution.
+type shortNameOptions struct {
+	mode        string // --short-name-mode
+	aliasesConf string // --short-name-aliases-conf
+}
+
+// shortNameFlags prepares a collection of CLI flags writing into shortNameOptions,
+// and the managed shortNameOptions structure.
+func shortNameFlags() ([]cli.Flag, *shortNameOptions) {
+	opts := shortNameOptions{mode: string(shortNameModeEnforcing)}
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:        "short-name-mode",
+			Usage:       "how to resolve unqualified image names: `MODE` is one of enforcing, permissive, or docker-hub",
+			Value:       string(shortNameModeEnforcing),
+			Destination: &opts.mode,
+		},
+		cli.StringFlag{
+			Name:        "short-name-aliases-conf",
+			Usage:       "`FILE` recording (and persisting) resolved short-name aliases, so later runs are deterministic",
+			Destination: &opts.aliasesConf,
+		},
+	}, &opts
+}
+
+// resolveShortNames qualifies every short (unqualified) name in images into a
+// fully-specified reference, leaving already-qualified references untouched.
+func resolveShortNames(images []string, opts *shortNameOptions, sys *types.SystemContext) ([]string, error) {
+	mode := shortNameMode(opts.mode)
+	switch mode {
+	case shortNameModeEnforcing, shortNameModePermissive, shortNameModeDockerHub:
+	default:
+		return nil, fmt.Errorf("invalid --short-name-mode %q: must be enforcing, permissive, or docker-hub", opts.mode)
+	}
+
+	resolved := make([]string, 0, len(images))
+	for _, image := range images {
+		name, err := resolveShortName(image, mode, opts.aliasesConf, sys)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, name)
+	}
+	return resolved, nil
+}
+
+// resolveShortName qualifies a single image name, leaving it unchanged if it
+// already carries an explicit registry or is not a docker-transport-style
+// reference at all (e.g. already prefixed with a transport like "docker://").
+func resolveShortName(image string, mode shortNameMode, aliasesConf string, sys *types.SystemContext) (string, error) {
+	if strings.Contains(image, "://") {
+		return image, nil
+	}
+
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		// Not something reference.ParseNormalizedNamed understands (e.g. a
+		// digest-only or malformed string); let the caller's later parsing
+		// surface the real error instead of failing resolution here.
+		return image, nil
+	}
+	if !isShortName(image) {
+		return image, nil
+	}
+
+	if mode == shortNameModeDockerHub {
+		repo := reference.Path(named)
+		if !strings.Contains(repo, "/") {
+			repo = "library/" + repo
+		}
+		result := "docker.io/" + repo
+		if tagged, ok := named.(reference.Tagged); ok {
+			result += ":" + tagged.Tag()
+		}
+		if digested, ok := named.(reference.Digested); ok {
+			result += "@" + digested.Digest().String()
+		}
+		return result, nil
+	}
+
+	resolvedOpts := &types.SystemContext{}
+	if sys != nil {
+		*resolvedOpts = *sys
+	}
+	if aliasesConf != "" {
+		resolvedOpts.UserShortNameAliasConfPath = aliasesConf
+	}
+	typesMode := shortNameModeToType(mode)
+	resolvedOpts.ShortNameMode = &typesMode
+
+	resolved, err := shortnames.Resolve(resolvedOpts, image)
+	if err != nil {
+		return "", errors.Wrapf(err, "resolving short name %q", image)
+	}
+
+	candidates := resolved.PullCandidates
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("short name %q did not resolve to any registry", image)
+	}
+	if len(candidates) > 1 && mode == shortNameModeEnforcing {
+		return "", fmt.Errorf("short name %q is ambiguous (matches %d unqualified-search registries); add an alias to --short-name-aliases-conf or pass --short-name-mode permissive", image, len(candidates))
+	}
+	if len(candidates) > 1 {
+		log.Infof("short name %q is ambiguous, using %s", image, candidates[0].Value.String())
+	}
+
+	chosen := candidates[0]
+	if aliasesConf != "" {
+		if err := shortnames.Add(resolvedOpts, image, chosen.Value); err != nil {
+			return "", errors.Wrapf(err, "persisting short-name alias for %q", image)
+		}
+	}
+	return chosen.Value.String(), nil
+}
+
+// isShortName reports whether image has no explicit registry component, i.e.
+// it would need unqualified-search-registries or an alias to resolve. The raw
+// string is inspected because reference.ParseNormalizedNamed always fills in
+// docker.io, hiding whether a registry was actually written.
+func isShortName(image string) bool {
+	slash := strings.Index(image, "/")
+	if slash == -1 {
+		return true
+	}
+	host := image[:slash]
+	return !strings.ContainsAny(host, ".:") && host != "localhost"
+}
+
+// shortNameModeToType maps our --short-name-mode flag value to the
+// types.ShortNameMode shortnames.Resolve actually branches on, so the flag
+// isn't silently overridden by registries.conf's short-name-mode setting.
+func shortNameModeToType(mode shortNameMode) types.ShortNameMode {
+	if mode == shortNameModePermissive {
+		return types.ShortNameModePermissive
+	}
+	return types.ShortNameModeEnforcing
+}
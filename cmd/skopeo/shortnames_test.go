@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/types"
+)
+
+func TestResolveShortNameDockerHub(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"nginx", "docker.io/library/nginx"},
+		{"nginx:1.21", "docker.io/library/nginx:1.21"},
+		{"bitnami/redis", "docker.io/bitnami/redis"},
+		{"myorg/myapp:v1", "docker.io/myorg/myapp:v1"},
+		{"docker.io/bitnami/redis", "docker.io/bitnami/redis"},
+	}
+	for _, tt := range tests {
+		got, err := resolveShortName(tt.image, shortNameModeDockerHub, "", nil)
+		if err != nil {
+			t.Errorf("resolveShortName(%q) returned error: %v", tt.image, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolveShortName(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestShortNameModeToType(t *testing.T) {
+	if got := shortNameModeToType(shortNameModePermissive); got != types.ShortNameModePermissive {
+		t.Errorf("shortNameModeToType(permissive) = %v, want ShortNameModePermissive", got)
+	}
+	if got := shortNameModeToType(shortNameModeEnforcing); got != types.ShortNameModeEnforcing {
+		t.Errorf("shortNameModeToType(enforcing) = %v, want ShortNameModeEnforcing", got)
+	}
+}
@@ -334,16 +334,20 @@ func unmarshalUnstructuredK8s(data string) (objs []*unstructured.Unstructured) {
 	return
 }
 
-func parseImagesFromManifests(yaml string) (images []string) {
-	objs := unmarshalUnstructuredK8s(yaml)
+// imagesFromManifestObjs extracts every image reference from an already
+// unmarshaled unstructured tree, using reg to pick an extractor per
+// resource's apiVersion+kind. A nil reg falls back to defaultExtractorRegistry.
+func imagesFromManifestObjs(reg extractorRegistry, objs []*unstructured.Unstructured) (images []string) {
+	if reg == nil {
+		reg = defaultExtractorRegistry
+	}
 
 	for _, obj := range objs {
-		// Loop through every yaml obj recursively until done
-		images = append(images, walkImage(obj.Object)...)
+		images = append(images, extractImages(reg, obj)...)
 
 		// Get any annotations
 		for k, v := range obj.GetAnnotations() {
-			// If we stumble upon the right annotation in a resource, add extra images to the list
+			// skopeo.io/extraimages always applies, on top of whatever the extractor found
 			if k == extraImageAnnotation {
 				images = append(images, strings.Split(v, ",")...)
 			}